@@ -16,27 +16,32 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
-	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"sort"
 	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
-	"github.com/go-martini/martini"
+	"github.com/bytting/bookmarkd/format"
 )
 
 // Commandline options
-var BookmarkFile string
+var BookmarkFiles bookmarkFiles
 var LogFile string
 var Port uint
 var UseSort bool
+var CheckInterval time.Duration
+var CheckConcurrency uint
+var CheckTimeout time.Duration
+
+// checker is the background reachability checker; it is nil until main
+// has parsed flags and wired it up.
+var checker *Checker
 
 // Bookmark structures, Chromium format
 type Children struct {
@@ -49,10 +54,30 @@ type Children struct {
 	Children     []Children `json:"children"`
 }
 
+// Bookmarks holds the currently loaded tree. Roots is replaced wholesale
+// by LoadBookmarks (on a root request, a stale /search or /api/v1/folder
+// lookup, or a periodic reachability check reading it concurrently), so
+// every access goes through mu to avoid racing with a reload.
 type Bookmarks struct {
+	mu    sync.RWMutex
 	Roots map[string]Children
 }
 
+// Folder returns a snapshot of the named root folder, safe to call while
+// another goroutine is reloading the tree.
+func (b *Bookmarks) Folder(name string) Children {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Roots[name]
+}
+
+// setRoots atomically replaces the whole tree, as loaded by LoadBookmarks.
+func (b *Bookmarks) setRoots(roots map[string]Children) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Roots = roots
+}
+
 // Make Children arrays sortable
 type Sortable []Children
 
@@ -68,114 +93,123 @@ func (s Sortable) Less(i, j int) bool {
 	return s[i].Name < s[j].Name
 }
 
-// LoadBookmarks loads bookmarks from file.
-// Data structure to store bookmarks are provided as argument.
-// Expects a Chromium compatible bookmark file stored in global variable 'BookmarkFile'
-// Returns nil, or error on failure
-func LoadBookmarks(b *Bookmarks) error {
-
-	// Load bookmarks from file
-	d, e := ioutil.ReadFile(BookmarkFile)
-	if e != nil {
-		return e
+// chromiumEpochOffset is the number of microseconds between the Windows
+// FILETIME epoch (1601-01-01) that Chromium timestamps are counted from
+// and the Unix epoch (1970-01-01).
+const chromiumEpochOffset = int64(11644473600000000)
+
+// chromiumToUnix converts a Chromium "microseconds since 1601" timestamp
+// string, as stored in Children.DateAdded/DateModified, to a Unix time.
+func chromiumToUnix(s string) time.Time {
+	us, e := strconv.ParseInt(s, 10, 64)
+	if e != nil || us == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, (us-chromiumEpochOffset)*1000)
+}
 
-	// Deserialize JSON formatted bookmarks
-	if e := json.Unmarshal(d, b); e != nil {
-		return e
+// unixToChromium converts t to a Chromium "microseconds since 1601"
+// timestamp string, the inverse of chromiumToUnix.
+func unixToChromium(t time.Time) string {
+	if t.IsZero() {
+		return "0"
 	}
-
-	return nil
+	return strconv.FormatInt(t.UnixMicro()+chromiumEpochOffset, 10)
 }
 
-// handleRequest handles http requests.
-// Data structures for bookmarks and a http template are provided by martini as arguments
-func handleRequest(w http.ResponseWriter, r *http.Request, b *Bookmarks, t *template.Template) {
-
-	r.ParseForm()
-
-	// Extract form params
-	args := r.Form["fp"]
-	if len(args) == 0 {
-		// Load bookmarks from file if this is a root request
-		log.Printf("Loading bookmarks from %s\n", BookmarkFile)
-		e := LoadBookmarks(b)
-		if e != nil {
-			log.Println(e)
-			os.Exit(1)
+// childrenToNodes converts the Chromium bookmark tree rooted at c into the
+// format-neutral representation used by the format package.
+func childrenToNodes(c []Children) []format.Node {
+	nodes := make([]format.Node, 0, len(c))
+	for _, entry := range c {
+		node := format.Node{
+			Name:         entry.Name,
+			Url:          entry.Url,
+			Folder:       entry.Type == "folder",
+			DateAdded:    chromiumToUnix(entry.DateAdded),
+			DateModified: chromiumToUnix(entry.DateModified),
 		}
-	}
-
-	bar := b.Roots["bookmark_bar"]
-	children := bar.Children
-	nav := ""
-
-	// Iterate through the form params, updating current bookmark and nav levels
-	for _, arg := range args {
-		nav += " > " + arg
-		for i := 0; i < len(children); i++ {
-			if arg == children[i].Name {
-				children = children[i].Children
-				break
-			}
+		if node.Folder {
+			node.Children = childrenToNodes(entry.Children)
 		}
+		nodes = append(nodes, node)
 	}
+	return nodes
+}
 
-	// Sort bookmarks if the sort option is set
-	if UseSort {
-		sort.Sort(Sortable(children))
+// nodesToChildren converts format-neutral nodes, as produced by parsing a
+// Netscape Bookmark File, into the Chromium bookmark tree shape.
+func nodesToChildren(nodes []format.Node) []Children {
+	c := make([]Children, 0, len(nodes))
+	for _, n := range nodes {
+		entry := Children{
+			Name:         n.Name,
+			Url:          n.Url,
+			DateAdded:    unixToChromium(n.DateAdded),
+			DateModified: unixToChromium(n.DateModified),
+		}
+		if n.Folder {
+			entry.Type = "folder"
+			entry.Children = nodesToChildren(n.Children)
+		} else {
+			entry.Type = "url"
+		}
+		c = append(c, entry)
 	}
+	return c
+}
 
-	offs := "&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;"
-	curl, _ := url.Parse("http://" + r.Host + r.URL.String())
-	html := template.HTML("<a href='" + "http://" + r.Host + "'>" + offs + "[BOOKMARKS]</a>" + nav + "<br><br>")
-
-	// Iterate through bookmarks at current level
-	for _, entry := range children {
-
-		if entry.Type == "folder" {
-			// Build new chain of URL params, add this folder at the end
-			params := url.Values{}
-			for _, arg := range args {
-				params.Add("fp", arg)
-			}
-			params.Add("fp", entry.Name)
-			curl.RawQuery = params.Encode()
+// LoadBookmarks loads bookmarks from every configured -bookmarkfile.
+// Data structure to store bookmarks are provided as argument.
+// Each file in the global 'BookmarkFiles' list is handed to NewSource,
+// which auto-detects whether it is a Chromium JSON file, a Firefox
+// places.sqlite database, or a Netscape Bookmark File. The bookmarks
+// from each source are merged under a synthetic top-level folder named
+// after Source.Name(), so the folder-navigation UI transparently
+// browses across browsers.
+// Returns nil, or error on failure
+func LoadBookmarks(b *Bookmarks) error {
 
-			// Add this bookmark folder to the html template
-			html += template.HTML("<a href='" + curl.String() + "'>" + offs + "<img src='data:image/png;base64," + PNG_Folder + "'></img>&nbsp;" + entry.Name + "</a><br>")
+	var merged []Children
 
-		} else if entry.Type == "url" {
+	for _, path := range BookmarkFiles.values {
+		src, e := NewSource(path)
+		if e != nil {
+			return e
+		}
 
-			// Add this bookmark to the html template
-			html += template.HTML("<a href='" + entry.Url + "'>" + offs + "<img src='data:image/png;base64," + PNG_File + "'></img>&nbsp;" + entry.Name + "</a><br>")
+		children, e := src.Load(context.Background())
+		if e != nil {
+			return e
 		}
-	}
 
-	// Render template
-	if e := t.Execute(w, html); e != nil {
-		log.Println(e)
+		merged = append(merged, Children{
+			Name:     src.Name(),
+			Type:     "folder",
+			Children: children,
+		})
 	}
+
+	b.setRoots(map[string]Children{
+		"bookmark_bar": {Name: "bookmark_bar", Type: "folder", Children: merged},
+	})
+
+	return nil
 }
 
 // main driver
 func main() {
 
-	// Set up signals
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	signal.Notify(c, syscall.SIGTERM)
-	go func() {
-		<-c
-		os.Exit(0)
-	}()
-
 	// Parse command line options
 	homeDir := os.Getenv("HOME")
-	flag.StringVar(&BookmarkFile, "bookmarkfile", homeDir+"/.config/chromium/Default/Bookmarks", "The bookmark file")
+	BookmarkFiles = bookmarkFiles{values: []string{homeDir + "/.config/chromium/Default/Bookmarks"}}
+	flag.Var(&BookmarkFiles, "bookmarkfile", "The bookmark file (repeatable, merges bookmarks from multiple browsers)")
 	flag.StringVar(&LogFile, "logfile", homeDir+"/.config/bookmarkd.log", "The log file")
 	flag.UintVar(&Port, "port", 9898, "The listening port")
 	flag.BoolVar(&UseSort, "use-sort", false, "Sort bookmarks alphabetically")
+	flag.DurationVar(&CheckInterval, "check-interval", 30*time.Minute, "How often to check bookmarked URLs for reachability")
+	flag.UintVar(&CheckConcurrency, "check-concurrency", 8, "Maximum number of concurrent reachability checks")
+	flag.DurationVar(&CheckTimeout, "check-timeout", 10*time.Second, "Timeout for a single reachability check")
 	flag.Parse()
 
 	// Set up log file
@@ -192,29 +226,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	if _, e := os.Stat(BookmarkFile); e != nil {
-		log.Println("Bookmark file not found: " + BookmarkFile)
-		os.Exit(1)
+	for _, path := range BookmarkFiles.values {
+		if _, e := os.Stat(path); e != nil {
+			log.Println("Bookmark file not found: " + path)
+			os.Exit(1)
+		}
 	}
 
-	// Set up martini
-	m := martini.Classic()
-
 	bm := new(Bookmarks)
-	m.Map(bm)
 
-	templ, e := template.New("index").Parse(TEMPL_Index)
-	if e != nil {
-		log.Println("Failed to create template")
-		os.Exit(1)
-	}
-	m.Map(templ)
+	// Set up the background reachability checker
+	checker = NewChecker(homeDir+"/.config/bookmarkd-checks.json", int(CheckConcurrency), CheckTimeout)
+	go checker.RunPeriodically(bm, CheckInterval)
 
-	m.Get("/", handleRequest)
+	srv := NewServer(bm, Config{UseSort: UseSort})
 
-	sPort := ":" + strconv.Itoa(int(Port))
+	httpServer := &http.Server{
+		Addr:    ":" + strconv.Itoa(int(Port)),
+		Handler: srv.Routes(),
+	}
+
+	// Trap SIGINT/SIGTERM and shut down gracefully instead of os.Exit(0),
+	// so in-flight requests get to finish.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		log.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if e := httpServer.Shutdown(ctx); e != nil {
+			log.Println(e)
+		}
+	}()
 
 	// Start service
-	log.Printf("Start listening on localhost%s\n", sPort)
-	m.RunOnAddr(sPort)
+	log.Printf("Start listening on localhost%s\n", httpServer.Addr)
+	if e := httpServer.ListenAndServe(); e != nil && e != http.ErrServerClosed {
+		log.Println(e)
+	}
 }
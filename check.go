@@ -0,0 +1,264 @@
+/*
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CheckResult is the outcome of the most recent reachability check of a
+// single bookmarked URL.
+type CheckResult struct {
+	Url           string    `json:"url"`
+	StatusCode    int       `json:"status_code"`
+	Error         string    `json:"error,omitempty"`
+	LatencyMs     int64     `json:"latency_ms"`
+	LastChecked   time.Time `json:"last_checked"`
+	RedirectChain []string  `json:"redirect_chain,omitempty"`
+}
+
+// Dead reports whether the check should be treated as link rot: no
+// response at all, or a 4xx/5xx status.
+func (c CheckResult) Dead() bool {
+	return c.Error != "" || c.StatusCode >= 400
+}
+
+// Redirected reports whether the URL resolved somewhere other than
+// itself.
+func (c CheckResult) Redirected() bool {
+	return len(c.RedirectChain) > 0
+}
+
+// Checker periodically walks the bookmark tree and records whether each
+// URL is reachable, turning bookmarkd from a passive viewer into a
+// link-rot monitor.
+type Checker struct {
+	mu          sync.RWMutex
+	results     map[string]CheckResult
+	sidecarPath string
+	concurrency int
+	timeout     time.Duration
+}
+
+// NewChecker creates a Checker that persists its results to sidecarPath,
+// loading any results already on disk from a previous run.
+func NewChecker(sidecarPath string, concurrency int, timeout time.Duration) *Checker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c := &Checker{
+		results:     map[string]CheckResult{},
+		sidecarPath: sidecarPath,
+		concurrency: concurrency,
+		timeout:     timeout,
+	}
+	c.load()
+	return c
+}
+
+func (c *Checker) load() {
+	d, e := ioutil.ReadFile(c.sidecarPath)
+	if e != nil {
+		return
+	}
+	var results map[string]CheckResult
+	if e := json.Unmarshal(d, &results); e != nil {
+		log.Println("Failed to load check results: " + e.Error())
+		return
+	}
+	c.mu.Lock()
+	c.results = results
+	c.mu.Unlock()
+}
+
+func (c *Checker) save() {
+	c.mu.RLock()
+	d, e := json.MarshalIndent(c.results, "", "  ")
+	c.mu.RUnlock()
+	if e != nil {
+		log.Println(e)
+		return
+	}
+	if e := ioutil.WriteFile(c.sidecarPath, d, 0644); e != nil {
+		log.Println("Failed to save check results: " + e.Error())
+	}
+}
+
+// Result returns the most recent check result for url, if any.
+func (c *Checker) Result(url string) (CheckResult, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	r, ok := c.results[url]
+	return r, ok
+}
+
+// All returns a copy of every known check result.
+func (c *Checker) All() []CheckResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	all := make([]CheckResult, 0, len(c.results))
+	for _, r := range c.results {
+		all = append(all, r)
+	}
+	return all
+}
+
+// Run walks every url Children in b and checks it, honouring the
+// Checker's configured concurrency limit, then persists the results to
+// the sidecar file.
+func (c *Checker) Run(b *Bookmarks) {
+	urls := collectUrls(b.Folder("bookmark_bar").Children, nil)
+
+	sem := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+
+	for _, u := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(u string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result := c.checkOne(u)
+			c.mu.Lock()
+			c.results[u] = result
+			c.mu.Unlock()
+		}(u)
+	}
+
+	wg.Wait()
+	c.save()
+}
+
+// RunPeriodically calls Run every interval until the program exits.
+func (c *Checker) RunPeriodically(b *Bookmarks, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		c.Run(b)
+	}
+}
+
+func (c *Checker) checkOne(rawUrl string) CheckResult {
+	client := &http.Client{
+		Timeout: c.timeout,
+	}
+
+	var chain []string
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		chain = append(chain, req.URL.String())
+		if len(via) >= 10 {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+
+	start := time.Now()
+	resp, e := client.Head(rawUrl)
+	if e != nil || resp.StatusCode == http.StatusMethodNotAllowed {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		chain = nil
+		start = time.Now()
+		resp, e = client.Get(rawUrl)
+	}
+
+	result := CheckResult{
+		Url:           rawUrl,
+		LatencyMs:     time.Since(start).Milliseconds(),
+		LastChecked:   time.Now(),
+		RedirectChain: chain,
+	}
+
+	if e != nil {
+		result.Error = e.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	return result
+}
+
+// collectUrls walks children, appending the Url of every entry of type
+// "url" and recursing into folders.
+func collectUrls(children []Children, into []string) []string {
+	for _, entry := range children {
+		if entry.Type == "url" {
+			into = append(into, entry.Url)
+		} else if entry.Type == "folder" {
+			into = collectUrls(entry.Children, into)
+		}
+	}
+	return into
+}
+
+// handleCheck renders a report of dead and redirected bookmarks.
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
+
+	results := checker.All()
+
+	html := template.HTML("<a href='http://" + r.Host + "'>&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;[BOOKMARKS]</a> &gt; Link check report<br><br>")
+	html += template.HTML("<table border='1' cellpadding='4'><tr><th>Status</th><th>URL</th><th>Latency</th><th>Last checked</th><th>Redirects</th></tr>")
+
+	for _, res := range results {
+		status := "OK"
+		if res.Dead() {
+			status = "DEAD"
+		} else if res.Redirected() {
+			status = "REDIRECT"
+		}
+
+		html += template.HTML("<tr><td>" + status + "</td><td><a href='" + template.HTMLEscapeString(res.Url) + "'>" + template.HTMLEscapeString(res.Url) + "</a></td><td>" +
+			time.Duration(res.LatencyMs*int64(time.Millisecond)).String() + "</td><td>" + res.LastChecked.Format(time.RFC3339) + "</td><td>")
+
+		for _, hop := range res.RedirectChain {
+			html += template.HTML(template.HTMLEscapeString(hop) + "<br>")
+		}
+
+		html += "</td></tr>"
+	}
+
+	html += "</table>"
+
+	if e := s.Templates.ExecuteTemplate(w, "page", html); e != nil {
+		log.Println(e)
+	}
+}
+
+// statusDot returns a short inline marker reflecting the last known
+// reachability of url, for annotating the normal browse view. It is
+// empty if the URL has not been checked yet, or if the checker hasn't
+// been wired up (e.g. a handler exercised directly, without main's setup).
+func statusDot(url string) template.HTML {
+	if checker == nil {
+		return ""
+	}
+	res, ok := checker.Result(url)
+	if !ok {
+		return ""
+	}
+	color := "green"
+	if res.Dead() {
+		color = "red"
+	} else if res.Redirected() {
+		color = "orange"
+	}
+	return template.HTML("<span title='" + template.HTMLEscapeString(res.LastChecked.Format(time.RFC3339)) + "' style='color:" + color + "'>&#9679;</span>&nbsp;")
+}
@@ -0,0 +1,170 @@
+/*
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package format implements parsing and serialization of third-party
+// bookmark interchange formats, starting with the classic Netscape
+// Bookmark File Format (the DOCTYPE NETSCAPE-Bookmark-file-1 DL/DT/A
+// tree exported by Firefox, Safari, Pinboard, Delicious and friends).
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node is a format-neutral bookmark entry. It is either a folder, in
+// which case Children may be populated, or a leaf bookmark, in which
+// case Url is set.
+type Node struct {
+	Name         string
+	Url          string
+	Folder       bool
+	DateAdded    time.Time
+	DateModified time.Time
+	Children     []Node
+}
+
+const doctype = "<!DOCTYPE NETSCAPE-Bookmark-file-1>"
+
+// Sniff reports whether data looks like a Netscape Bookmark File, by
+// checking for the DOCTYPE that every exporter emits as the first
+// non-whitespace content.
+func Sniff(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return strings.HasPrefix(strings.ToUpper(string(trimmed)), strings.ToUpper(doctype))
+}
+
+var (
+	folderOpenRe  = regexp.MustCompile(`(?i)<DT><H3([^>]*)>(.*?)</H3>`)
+	bookmarkRe    = regexp.MustCompile(`(?i)<DT><A\s+([^>]*)>(.*?)</A>`)
+	folderCloseRe = regexp.MustCompile(`(?i)</DL>`)
+	attrRe        = regexp.MustCompile(`(?i)([A-Z_]+)="([^"]*)"`)
+)
+
+// Parse reads a Netscape Bookmark File and returns the root folder's
+// children as a tree of Nodes.
+func Parse(r io.Reader) ([]Node, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	root := &Node{Folder: true}
+	stack := []*Node{root}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if m := folderOpenRe.FindStringSubmatch(line); m != nil {
+			folder := Node{Name: html.UnescapeString(m[2]), Folder: true}
+			for _, am := range attrRe.FindAllStringSubmatch(m[1], -1) {
+				switch strings.ToUpper(am[1]) {
+				case "ADD_DATE":
+					if secs, e := strconv.ParseInt(am[2], 10, 64); e == nil {
+						folder.DateAdded = time.Unix(secs, 0)
+					}
+				case "LAST_MODIFIED":
+					if secs, e := strconv.ParseInt(am[2], 10, 64); e == nil {
+						folder.DateModified = time.Unix(secs, 0)
+					}
+				}
+			}
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, folder)
+			stack = append(stack, &top.Children[len(top.Children)-1])
+			continue
+		}
+
+		if m := bookmarkRe.FindStringSubmatch(line); m != nil {
+			node := Node{Name: html.UnescapeString(m[2])}
+			for _, am := range attrRe.FindAllStringSubmatch(m[1], -1) {
+				switch strings.ToUpper(am[1]) {
+				case "HREF":
+					node.Url = html.UnescapeString(am[2])
+				case "ADD_DATE":
+					if secs, e := strconv.ParseInt(am[2], 10, 64); e == nil {
+						node.DateAdded = time.Unix(secs, 0)
+					}
+				case "LAST_MODIFIED":
+					if secs, e := strconv.ParseInt(am[2], 10, 64); e == nil {
+						node.DateModified = time.Unix(secs, 0)
+					}
+				}
+			}
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, node)
+			continue
+		}
+
+		if folderCloseRe.MatchString(line) && len(stack) > 1 {
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if e := scanner.Err(); e != nil {
+		return nil, e
+	}
+
+	return root.Children, nil
+}
+
+// Write serializes nodes as a Netscape Bookmark File to w.
+func Write(w io.Writer, nodes []Node) error {
+	if _, e := fmt.Fprintf(w, "%s\n<!-- This is an automatically generated file.\n     It will be read and overwritten.\n     DO NOT EDIT! -->\n<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n<TITLE>Bookmarks</TITLE>\n<H1>Bookmarks</H1>\n<DL><p>\n", doctype); e != nil {
+		return e
+	}
+	if e := writeNodes(w, nodes, 1); e != nil {
+		return e
+	}
+	_, e := fmt.Fprint(w, "</DL><p>\n")
+	return e
+}
+
+// unixOrZero returns t.Unix(), or 0 if t is the zero time (no timestamp
+// available), since t.Unix() on a zero time.Time is a large negative number
+// rather than 0.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func writeNodes(w io.Writer, nodes []Node, depth int) error {
+	indent := strings.Repeat("    ", depth)
+	for _, n := range nodes {
+		if n.Folder {
+			if _, e := fmt.Fprintf(w, "%s<DT><H3 ADD_DATE=\"%d\" LAST_MODIFIED=\"%d\">%s</H3>\n%s<DL><p>\n",
+				indent, unixOrZero(n.DateAdded), unixOrZero(n.DateModified), html.EscapeString(n.Name), indent); e != nil {
+				return e
+			}
+			if e := writeNodes(w, n.Children, depth+1); e != nil {
+				return e
+			}
+			if _, e := fmt.Fprintf(w, "%s</DL><p>\n", indent); e != nil {
+				return e
+			}
+		} else {
+			if _, e := fmt.Fprintf(w, "%s<DT><A HREF=\"%s\" ADD_DATE=\"%d\" LAST_MODIFIED=\"%d\">%s</A>\n",
+				indent, html.EscapeString(n.Url), unixOrZero(n.DateAdded), unixOrZero(n.DateModified), html.EscapeString(n.Name)); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSniff(t *testing.T) {
+	if !Sniff([]byte("  \n<!DOCTYPE NETSCAPE-Bookmark-file-1>\n<DL><p>\n")) {
+		t.Error("Sniff() = false for a Netscape bookmark file")
+	}
+	if Sniff([]byte(`{"roots":{}}`)) {
+		t.Error("Sniff() = true for a JSON bookmark file")
+	}
+}
+
+func TestParseWriteRoundTrip(t *testing.T) {
+	added := time.Unix(1700000000, 0)
+	modified := time.Unix(1700000100, 0)
+
+	original := []Node{
+		{
+			Name:         "Tools",
+			Folder:       true,
+			DateAdded:    added,
+			DateModified: modified,
+			Children: []Node{
+				{Name: "It's <Go>", Url: "https://go.dev", DateAdded: added, DateModified: modified},
+			},
+		},
+		{Name: "Example", Url: "https://example.com", DateAdded: added, DateModified: modified},
+	}
+
+	var buf bytes.Buffer
+	if e := Write(&buf, original); e != nil {
+		t.Fatalf("Write() error = %v", e)
+	}
+
+	if !Sniff(buf.Bytes()) {
+		t.Fatalf("Write() output does not Sniff() as a Netscape bookmark file:\n%s", buf.String())
+	}
+
+	parsed, e := Parse(&buf)
+	if e != nil {
+		t.Fatalf("Parse() error = %v", e)
+	}
+
+	assertNodesEqual(t, original, parsed)
+}
+
+func assertNodesEqual(t *testing.T, want, got []Node) {
+	t.Helper()
+
+	if len(want) != len(got) {
+		t.Fatalf("node count = %d, want %d (%+v)", len(got), len(want), got)
+	}
+
+	for i := range want {
+		w, g := want[i], got[i]
+		if w.Name != g.Name || w.Url != g.Url || w.Folder != g.Folder {
+			t.Errorf("node %d = %+v, want %+v", i, g, w)
+		}
+		if !w.DateAdded.Equal(g.DateAdded) {
+			t.Errorf("node %d DateAdded = %v, want %v", i, g.DateAdded, w.DateAdded)
+		}
+		if !w.DateModified.Equal(g.DateModified) {
+			t.Errorf("node %d DateModified = %v, want %v", i, g.DateModified, w.DateModified)
+		}
+		assertNodesEqual(t, w.Children, g.Children)
+	}
+}
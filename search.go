@@ -0,0 +1,176 @@
+/*
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// lastLoaded is the most recent mtime, across all BookmarkFiles, that was
+// in effect the last time LoadBookmarks ran. It lets both the root
+// request and the search endpoint notice the files changed on disk and
+// reload without waiting for the other to do so.
+var (
+	lastLoadedMu sync.Mutex
+	lastLoaded   int64
+)
+
+// maxBookmarkFileMtime returns the newest mtime, as a Unix timestamp,
+// across all configured bookmark files.
+func maxBookmarkFileMtime() int64 {
+	var newest int64
+	for _, path := range BookmarkFiles.values {
+		fi, e := os.Stat(path)
+		if e != nil {
+			continue
+		}
+		if m := fi.ModTime().Unix(); m > newest {
+			newest = m
+		}
+	}
+	return newest
+}
+
+// markLoaded records that bookmarks were just (re)loaded, so future
+// reloadIfStale calls only reload again once a file's mtime moves past
+// this point.
+func markLoaded() {
+	lastLoadedMu.Lock()
+	defer lastLoadedMu.Unlock()
+	lastLoaded = maxBookmarkFileMtime()
+}
+
+// reloadIfStale reloads b if any configured bookmark file has a newer
+// mtime than the last time bookmarks were loaded.
+func reloadIfStale(b *Bookmarks) error {
+	lastLoadedMu.Lock()
+	newest := maxBookmarkFileMtime()
+	stale := newest > lastLoaded
+	lastLoadedMu.Unlock()
+
+	if !stale {
+		return nil
+	}
+
+	if e := LoadBookmarks(b); e != nil {
+		return e
+	}
+	markLoaded()
+	return nil
+}
+
+// searchHit is a single matched bookmark or folder, together with the
+// breadcrumb path of folder names leading to it.
+type searchHit struct {
+	entry      Children
+	breadcrumb string
+}
+
+// searchTree walks the full bookmark tree, matching entry.Name and
+// entry.Url against query using match, and returns every hit with its
+// breadcrumb path.
+func searchTree(children []Children, breadcrumb string, query string, match func(haystack, needle string) bool) []searchHit {
+	var hits []searchHit
+
+	for _, entry := range children {
+		if match(strings.ToLower(entry.Name), query) || match(strings.ToLower(entry.Url), query) {
+			hits = append(hits, searchHit{entry: entry, breadcrumb: breadcrumb})
+		}
+		if entry.Type == "folder" {
+			next := breadcrumb
+			if next != "" {
+				next += " > "
+			}
+			next += entry.Name
+			hits = append(hits, searchTree(entry.Children, next, query, match)...)
+		}
+	}
+
+	return hits
+}
+
+// fuzzyMatch reports whether needle occurs in haystack as a subsequence,
+// in the style of fzf: characters of needle must appear in haystack in
+// order, but not necessarily contiguously.
+func fuzzyMatch(haystack, needle string) bool {
+	if needle == "" {
+		return true
+	}
+	runes := []rune(needle)
+	i := 0
+	for _, r := range haystack {
+		if r == runes[i] {
+			i++
+			if i == len(runes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleSearch handles GET /search?q=...&mode=fuzzy, matching against
+// every bookmark and folder name/URL in the tree rather than just the
+// current folder.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+
+	r.ParseForm()
+
+	if e := reloadIfStale(s.Bookmarks); e != nil {
+		log.Println(e)
+		http.Error(w, "failed to load bookmarks", http.StatusInternalServerError)
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(r.Form.Get("q")))
+
+	match := strings.Contains
+	if r.Form.Get("mode") == "fuzzy" {
+		match = fuzzyMatch
+	}
+
+	var hits []searchHit
+	if query != "" {
+		bar := s.Bookmarks.Folder("bookmark_bar")
+		hits = searchTree(bar.Children, "", query, match)
+	}
+
+	offs := "&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;"
+	html := template.HTML("<a href='http://" + r.Host + "'>" + offs + "[BOOKMARKS]</a> &gt; Search results for &quot;" + template.HTMLEscapeString(r.Form.Get("q")) + "&quot;<br><br>")
+
+	for _, hit := range hits {
+		icon := "&#128196;"
+		href := template.HTMLEscapeString(hit.entry.Url)
+		if hit.entry.Type == "folder" {
+			icon = "&#128193;"
+			href = "#"
+		}
+
+		crumb := hit.breadcrumb
+		if crumb != "" {
+			crumb = " <small>(" + template.HTMLEscapeString(crumb) + ")</small>"
+		}
+
+		html += template.HTML("<a href='" + href + "'>" + offs + icon + "&nbsp;" + template.HTMLEscapeString(hit.entry.Name) + "</a>" + crumb + "<br>")
+	}
+
+	if e := s.Templates.ExecuteTemplate(w, "page", html); e != nil {
+		log.Println(e)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/bytting/bookmarkd/format"
+)
+
+// Config holds the request-handling options a Server needs, as opposed
+// to the loader/checker subsystem flags (-bookmarkfile, -check-*) which
+// those subsystems keep bound to their own package-level vars.
+type Config struct {
+	UseSort bool
+}
+
+// Server holds every dependency handlers need, passed in explicitly
+// instead of being injected by reflection the way martini did it. This
+// makes the handlers plain methods that are straightforward to unit
+// test with a Server built by hand.
+type Server struct {
+	Bookmarks *Bookmarks
+	Templates *template.Template
+	Config    Config
+}
+
+// NewServer builds a Server ready to have Routes mounted.
+func NewServer(bookmarks *Bookmarks, cfg Config) *Server {
+	return &Server{
+		Bookmarks: bookmarks,
+		Templates: newTemplates(),
+		Config:    cfg,
+	}
+}
+
+// Routes returns the chi router for the whole application.
+func (s *Server) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", s.handleRequest)
+	r.Get("/export", s.handleExport)
+	r.Get("/search", s.handleSearch)
+	r.Get("/check", s.handleCheck)
+	r.Get("/api/v1/folder", s.handleAPIFolder)
+	return r
+}
+
+// handleRequest handles http requests for the browse view. It is split
+// into a data layer (folderChildren) that resolves the requested path to
+// a []Children, and a rendering layer (buildFolderView, the "browse"
+// template) that turns them into HTML.
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+
+	r.ParseForm()
+
+	// Extract form params
+	path := r.Form["fp"]
+	if len(path) == 0 {
+		// Load bookmarks from file if this is a root request
+		log.Printf("Loading bookmarks from %d source(s)\n", len(BookmarkFiles.values))
+		if e := LoadBookmarks(s.Bookmarks); e != nil {
+			log.Println(e)
+			os.Exit(1)
+		}
+		markLoaded()
+	}
+
+	children := folderChildren(s.Bookmarks, path)
+	view := buildFolderView(r, path, children, s.Config.UseSort)
+
+	if e := s.Templates.ExecuteTemplate(w, "browse", view); e != nil {
+		log.Println(e)
+	}
+}
+
+// handleExport renders the currently loaded Bookmarks tree as a bookmark
+// interchange file. The only format supported so far is Netscape, selected
+// with ?format=netscape.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+
+	r.ParseForm()
+
+	if r.Form.Get("format") != "netscape" {
+		http.Error(w, "unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	bar := s.Bookmarks.Folder("bookmark_bar")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=bookmarks.html")
+
+	if e := format.Write(w, childrenToNodes(bar.Children)); e != nil {
+		log.Println(e)
+	}
+}
+
+// apiChild is the JSON representation of a single bookmark or folder
+// returned by the /api/v1/folder endpoint.
+type apiChild struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Url       string `json:"url,omitempty"`
+	DateAdded string `json:"date_added,omitempty"`
+}
+
+// apiFolderResponse is the JSON body returned by /api/v1/folder.
+type apiFolderResponse struct {
+	Folder     string     `json:"folder"`
+	Breadcrumb []string   `json:"breadcrumb"`
+	Children   []apiChild `json:"children"`
+}
+
+// handleAPIFolder serves GET /api/v1/folder?fp=a&fp=b, the JSON
+// equivalent of handleRequest's browse view, for third-party frontends
+// and browser extensions.
+func (s *Server) handleAPIFolder(w http.ResponseWriter, r *http.Request) {
+
+	r.ParseForm()
+	path := r.Form["fp"]
+
+	if e := reloadIfStale(s.Bookmarks); e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	children := folderChildren(s.Bookmarks, path)
+
+	folder := "bookmark_bar"
+	if len(path) > 0 {
+		folder = path[len(path)-1]
+	}
+
+	resp := apiFolderResponse{Folder: folder, Breadcrumb: path, Children: make([]apiChild, 0, len(children))}
+	for _, entry := range children {
+		resp.Children = append(resp.Children, apiChild{
+			Type:      entry.Type,
+			Name:      entry.Name,
+			Url:       entry.Url,
+			DateAdded: entry.DateAdded,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if e := json.NewEncoder(w).Encode(resp); e != nil {
+		log.Println(e)
+	}
+}
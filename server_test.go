@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testServer() *Server {
+	bm := &Bookmarks{}
+	bm.setRoots(map[string]Children{
+		"bookmark_bar": {
+			Name: "bookmark_bar",
+			Type: "folder",
+			Children: []Children{
+				{Name: "Work", Type: "folder", Children: []Children{
+					{Name: "It's <fine>", Type: "url", Url: "https://example.com/a"},
+				}},
+				{Name: "Docs", Type: "url", Url: "https://example.com/b"},
+			},
+		},
+	})
+	return NewServer(bm, Config{})
+}
+
+func TestHandleRequestEscapesEntryNames(t *testing.T) {
+	s := testServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/?fp=Work", nil)
+	rec := httptest.NewRecorder()
+
+	s.handleRequest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	if strings.Contains(body, "<fine>") {
+		t.Errorf("response was not escaped, a bookmark name broke out of its tag: %s", body)
+	}
+	if !strings.Contains(body, "It&#39;s &lt;fine&gt;") {
+		t.Errorf("response missing escaped entry name, got: %s", body)
+	}
+	if !strings.Contains(body, "https://example.com/a") {
+		t.Errorf("response missing bookmark URL, got: %s", body)
+	}
+}
+
+func TestHandleAPIFolder(t *testing.T) {
+	s := testServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/folder?fp=Work", nil)
+	rec := httptest.NewRecorder()
+
+	s.handleAPIFolder(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var resp apiFolderResponse
+	if e := json.Unmarshal(rec.Body.Bytes(), &resp); e != nil {
+		t.Fatalf("response is not valid JSON: %v (%s)", e, rec.Body.String())
+	}
+
+	if resp.Folder != "Work" {
+		t.Errorf("Folder = %q, want %q", resp.Folder, "Work")
+	}
+	if len(resp.Children) != 1 || resp.Children[0].Url != "https://example.com/a" {
+		t.Errorf("Children = %+v, want a single entry for https://example.com/a", resp.Children)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/bytting/bookmarkd/format"
+)
+
+// Source loads a bookmark tree from a single backing store, such as a
+// Chromium JSON file, a Firefox places.sqlite database, or a Netscape
+// Bookmark File.
+type Source interface {
+	// Load reads and parses the source, returning its bookmarks as a
+	// flat list of top-level Children.
+	Load(ctx context.Context) ([]Children, error)
+	// Name identifies the source for display, used as the name of the
+	// synthetic folder it is merged under.
+	Name() string
+}
+
+// bookmarkFiles holds the set of -bookmarkfile values; the flag may be
+// passed multiple times to aggregate bookmarks from several browsers.
+// The first explicit -bookmarkfile on the command line discards the
+// flag's default value rather than adding to it.
+type bookmarkFiles struct {
+	values []string
+	seen   bool
+}
+
+func (f *bookmarkFiles) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *bookmarkFiles) Set(value string) error {
+	if !f.seen {
+		f.values = nil
+		f.seen = true
+	}
+	f.values = append(f.values, value)
+	return nil
+}
+
+// sqliteMagic is the file header every SQLite database starts with,
+// used to recognize a Firefox places.sqlite file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// NewSource inspects path and returns the Source implementation able to
+// read it.
+func NewSource(path string) (Source, error) {
+	d, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+
+	if bytes.HasPrefix(d, []byte(sqliteMagic)) {
+		return &FirefoxSource{Path: path}, nil
+	}
+	if format.Sniff(d) {
+		return &NetscapeSource{Path: path, data: d}, nil
+	}
+	return &ChromiumSource{Path: path, data: d}, nil
+}
+
+// ChromiumSource loads bookmarks from a Chromium/Chrome "Bookmarks" JSON
+// file, the format this program originally supported exclusively.
+type ChromiumSource struct {
+	Path string
+	data []byte
+}
+
+func (s *ChromiumSource) Name() string {
+	return "Chromium (" + filepath.Base(filepath.Dir(s.Path)) + ")"
+}
+
+func (s *ChromiumSource) Load(ctx context.Context) ([]Children, error) {
+	var b Bookmarks
+	if e := json.Unmarshal(s.data, &b); e != nil {
+		return nil, e
+	}
+	return b.Roots["bookmark_bar"].Children, nil
+}
+
+// NetscapeSource loads bookmarks from a Netscape Bookmark File, as
+// exported by Firefox, Safari, Pinboard, Delicious and others.
+type NetscapeSource struct {
+	Path string
+	data []byte
+}
+
+func (s *NetscapeSource) Name() string {
+	return "Netscape (" + filepath.Base(s.Path) + ")"
+}
+
+func (s *NetscapeSource) Load(ctx context.Context) ([]Children, error) {
+	nodes, e := format.Parse(bytes.NewReader(s.data))
+	if e != nil {
+		return nil, e
+	}
+	return nodesToChildren(nodes), nil
+}
+
+// FirefoxSource loads bookmarks directly from a Firefox profile's
+// places.sqlite database.
+type FirefoxSource struct {
+	Path string
+}
+
+func (s *FirefoxSource) Name() string {
+	return "Firefox (" + filepath.Base(filepath.Dir(s.Path)) + ")"
+}
+
+// mozFolderType and mozBookmarkType are the moz_bookmarks.type values
+// identifying folders and URL bookmarks respectively.
+const (
+	mozFolderType   = 2
+	mozBookmarkType = 1
+)
+
+// firefoxToChromium converts a Firefox moz_bookmarks dateAdded/lastModified
+// value, in microseconds since the Unix epoch, to the microseconds-since-1601
+// string that Children.DateAdded/DateModified holds everywhere else.
+func firefoxToChromium(us int64) string {
+	if us == 0 {
+		return "0"
+	}
+	return strconv.FormatInt(us+chromiumEpochOffset, 10)
+}
+
+func (s *FirefoxSource) Load(ctx context.Context) ([]Children, error) {
+
+	// places.sqlite is locked with WAL/SHM files while Firefox is
+	// running, so open a copy instead of the live database.
+	tmp, e := copyToTemp(s.Path)
+	if e != nil {
+		return nil, e
+	}
+	defer os.Remove(tmp)
+
+	db, e := sql.Open("sqlite3", "file:"+tmp+"?mode=ro&immutable=1")
+	if e != nil {
+		return nil, e
+	}
+	defer db.Close()
+
+	rows, e := db.QueryContext(ctx, `
+		SELECT b.id, b.parent, b.type, b.title, b.dateAdded, b.lastModified, p.url
+		FROM moz_bookmarks b
+		LEFT JOIN moz_places p ON p.id = b.fk
+		ORDER BY b.parent, b.position`)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	type row struct {
+		id, parent, typ         int64
+		title, url              sql.NullString
+		dateAdded, lastModified int64
+	}
+	var all []row
+
+	for rows.Next() {
+		var r row
+		if e := rows.Scan(&r.id, &r.parent, &r.typ, &r.title, &r.dateAdded, &r.lastModified, &r.url); e != nil {
+			return nil, e
+		}
+		all = append(all, r)
+	}
+	if e := rows.Err(); e != nil {
+		return nil, e
+	}
+
+	nodeByID := map[int64]Children{}
+	childIDsByParent := map[int64][]int64{}
+
+	for _, r := range all {
+		switch r.typ {
+		case mozFolderType:
+			nodeByID[r.id] = Children{
+				Id:           fmt.Sprintf("%d", r.id),
+				Name:         r.title.String,
+				Type:         "folder",
+				DateAdded:    firefoxToChromium(r.dateAdded),
+				DateModified: firefoxToChromium(r.lastModified),
+			}
+		case mozBookmarkType:
+			if !r.url.Valid {
+				continue
+			}
+			nodeByID[r.id] = Children{
+				Id:           fmt.Sprintf("%d", r.id),
+				Name:         r.title.String,
+				Type:         "url",
+				Url:          r.url.String,
+				DateAdded:    firefoxToChromium(r.dateAdded),
+				DateModified: firefoxToChromium(r.lastModified),
+			}
+		default:
+			continue
+		}
+		if r.id != r.parent { // Firefox's synthetic root folder parents itself
+			childIDsByParent[r.parent] = append(childIDsByParent[r.parent], r.id)
+		}
+	}
+
+	// The Firefox "bookmarks toolbar" folder has id 3 in a fresh profile.
+	if _, ok := nodeByID[3]; !ok {
+		return nil, fmt.Errorf("firefox source: bookmarks toolbar folder not found")
+	}
+
+	return firefoxSubtree(3, nodeByID, childIDsByParent), nil
+}
+
+// firefoxSubtree recursively materializes the Children tree rooted at
+// id from the flat moz_bookmarks rows, since the query result's order
+// makes it impossible to fill in a folder's Children in a single pass.
+func firefoxSubtree(id int64, nodeByID map[int64]Children, childIDsByParent map[int64][]int64) []Children {
+	childIDs := childIDsByParent[id]
+	out := make([]Children, 0, len(childIDs))
+	for _, childID := range childIDs {
+		child := nodeByID[childID]
+		if child.Type == "folder" {
+			child.Children = firefoxSubtree(childID, nodeByID, childIDsByParent)
+		}
+		out = append(out, child)
+	}
+	return out
+}
+
+// copyToTemp copies path to a temporary file and returns its path, so a
+// locked SQLite database can still be opened read-only.
+func copyToTemp(path string) (string, error) {
+	d, e := ioutil.ReadFile(path)
+	if e != nil {
+		return "", e
+	}
+	f, e := ioutil.TempFile("", "bookmarkd-places-*.sqlite")
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	if _, e := f.Write(d); e != nil {
+		os.Remove(f.Name())
+		return "", e
+	}
+	return f.Name(), nil
+}
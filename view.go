@@ -0,0 +1,128 @@
+/*
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// BreadcrumbEntry is one link in the "you are here" trail shown above a
+// folder's contents.
+type BreadcrumbEntry struct {
+	Name string
+	Href string
+}
+
+// EntryView is a single bookmark or folder as rendered in the browse
+// view, with its navigation target already resolved.
+type EntryView struct {
+	Name      string
+	Url       string
+	Folder    bool
+	StatusDot template.HTML
+}
+
+// FolderView is the typed view-model for the browse template: the
+// contents of one folder, plus enough navigation context to render the
+// breadcrumb trail and folder links.
+type FolderView struct {
+	RootHref   string
+	Breadcrumb []BreadcrumbEntry
+	Entries    []EntryView
+}
+
+const browseTemplateSrc = `` +
+	`<a href="{{.RootHref}}">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;[BOOKMARKS]</a>` +
+	`{{range .Breadcrumb}} &gt; <a href="{{.Href}}">{{.Name}}</a>{{end}}<br><br>` +
+	`{{range .Entries}}` +
+	`{{if .Folder}}<a href="{{.Url}}">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&#128193;&nbsp;{{.Name}}</a><br>` +
+	`{{else}}<a href="{{.Url}}">&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&#128196;&nbsp;{{.StatusDot}}{{.Name}}</a><br>` +
+	`{{end}}{{end}}`
+
+// pageTemplateSrc wraps an already-built template.HTML fragment in a bare
+// HTML page. It backs the handlers (search, check) that still assemble
+// their own markup rather than using a typed view-model.
+const pageTemplateSrc = `<html><body>{{.}}</body></html>`
+
+// newTemplates parses the "browse" and "page" templates into a single
+// *template.Template, the shape the Server struct threads through to
+// handlers instead of relying on martini's injector.
+func newTemplates() *template.Template {
+	t := template.Must(template.New("browse").Parse(browseTemplateSrc))
+	template.Must(t.New("page").Parse(pageTemplateSrc))
+	return t
+}
+
+// folderChildren returns the Children at the given breadcrumb path,
+// walking down from the bookmark bar root. A path element with no
+// matching folder at its level is ignored, leaving the walk at its
+// current depth, mirroring the original browse behavior.
+func folderChildren(b *Bookmarks, path []string) []Children {
+	children := b.Folder("bookmark_bar").Children
+	for _, name := range path {
+		for i := range children {
+			if children[i].Name == name {
+				children = children[i].Children
+				break
+			}
+		}
+	}
+	return children
+}
+
+// buildFolderView is the rendering layer: it turns the data layer's
+// []Children, plus the request and the breadcrumb path that produced
+// them, into a FolderView ready to execute against the "browse" template.
+func buildFolderView(r *http.Request, path []string, children []Children, useSort bool) FolderView {
+
+	curl, _ := url.Parse("http://" + r.Host + r.URL.Path)
+	view := FolderView{RootHref: "http://" + r.Host}
+
+	for i, name := range path {
+		params := url.Values{}
+		for _, p := range path[:i+1] {
+			params.Add("fp", p)
+		}
+		curl.RawQuery = params.Encode()
+		view.Breadcrumb = append(view.Breadcrumb, BreadcrumbEntry{Name: name, Href: curl.String()})
+	}
+
+	if useSort {
+		sort.Sort(Sortable(children))
+	}
+
+	for _, entry := range children {
+		ev := EntryView{Name: entry.Name, Folder: entry.Type == "folder"}
+
+		if ev.Folder {
+			params := url.Values{}
+			for _, p := range path {
+				params.Add("fp", p)
+			}
+			params.Add("fp", entry.Name)
+			curl.RawQuery = params.Encode()
+			ev.Url = curl.String()
+		} else {
+			ev.Url = entry.Url
+			ev.StatusDot = statusDot(entry.Url)
+		}
+
+		view.Entries = append(view.Entries, ev)
+	}
+
+	return view
+}